@@ -5,11 +5,9 @@ package cmd
 
 import (
 	"fmt"
-	"os/exec"
 	"reflect"
-	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/prometheus/procfs"
 	"github.com/spf13/cobra"
@@ -17,26 +15,29 @@ import (
 
 	"github.com/cilium/cilium/api/v1/client/daemon"
 	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/cgroups"
 	"github.com/cilium/cilium/pkg/command"
 	"github.com/cilium/cilium/pkg/common"
 	"github.com/cilium/cilium/pkg/common/ipsec"
+	"github.com/cilium/cilium/pkg/ipsec/rekey"
 )
 
 const (
 	// Cilium uses reqid 1 to tie the IPsec security policies to their matching state
-	ciliumReqId = "1"
+	ciliumReqId = 1
 )
 
-var (
-	countErrors int
-	regex       = regexp.MustCompile("oseq[[:blank:]]0[xX]([[:xdigit:]]+)")
-)
+var countErrors int
 
 var encryptStatusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Display the current encryption state",
 	Run: func(cmd *cobra.Command, args []string) {
 		common.RequireRootPrivilege("cilium encrypt status")
+		if prometheusEnabled {
+			serveEncryptionMetrics()
+			return
+		}
 		getEncryptionMode()
 	},
 }
@@ -44,6 +45,12 @@ var encryptStatusCmd = &cobra.Command{
 func init() {
 	CncryptCmd.AddCommand(encryptStatusCmd)
 	command.AddOutputOption(encryptStatusCmd)
+	encryptStatusCmd.Flags().BoolVar(&prometheusEnabled, "prometheus", false,
+		"Keep running and expose IPsec/WireGuard metrics for Prometheus scraping")
+	encryptStatusCmd.Flags().StringVar(&prometheusListenAddr, "listen", ":9963",
+		"Address to expose the Prometheus metrics endpoint on when --prometheus is set")
+	encryptStatusCmd.Flags().StringVar(&prometheusWebConfigFile, "web-config-file", "",
+		"TLS/basic-auth config file for the Prometheus endpoint, see exporter-toolkit/web")
 }
 
 func getXfrmStats(mountPoint string) (int, map[string]int) {
@@ -73,36 +80,36 @@ func getXfrmStats(mountPoint string) (int, map[string]int) {
 	return countErrors, errorMap
 }
 
-func extractMaxSequenceNumber(ipOutput string) int64 {
-	maxSeqNum := int64(0)
-	lines := strings.Split(ipOutput, "\n")
-	for _, line := range lines {
-		matched := regex.FindStringSubmatchIndex(line)
-		if matched != nil {
-			oseq, err := strconv.ParseInt(line[matched[2]:matched[3]], 16, 64)
-			if err != nil {
-				Fatalf("Failed to parse sequence number '%s': %s",
-					line[matched[2]:matched[3]], err)
-			}
-			if oseq > maxSeqNum {
-				maxSeqNum = oseq
-			}
+// maxSequenceNumberFromStates returns the highest XFRM output sequence
+// number in use across the Cilium-managed SAs in states. The second return
+// value is false if none of the states carry a replay counter (e.g. no SAs
+// are installed yet).
+func maxSequenceNumberFromStates(states []netlink.XfrmState) (uint32, bool) {
+	var (
+		maxSeqNum uint32
+		found     bool
+	)
+	for _, state := range states {
+		if state.Reqid != ciliumReqId || state.Replay == nil {
+			continue
+		}
+		if state.Replay.OSeq > maxSeqNum {
+			maxSeqNum = state.Replay.OSeq
 		}
+		found = true
 	}
-	return maxSeqNum
+	return maxSeqNum, found
 }
 
-func maxSequenceNumber() string {
-	out, err := exec.Command("ip", "xfrm", "state", "list", "reqid", ciliumReqId).Output()
+// xfrmPolicyCount returns the number of security policies installed in the
+// kernel's SPD, combining state introspection with the policy side of the
+// IPsec configuration so `cilium encrypt status` can report both.
+func xfrmPolicyCount() (int, error) {
+	policies, err := netlink.XfrmPolicyList(netlink.FAMILY_ALL)
 	if err != nil {
-		Fatalf("Cannot get xfrm states: %s", err)
-	}
-	commandOutput := string(out)
-	maxSeqNum := extractMaxSequenceNumber(commandOutput)
-	if maxSeqNum == 0 {
-		return "N/A"
+		return 0, fmt.Errorf("cannot list xfrm policies: %w", err)
 	}
-	return fmt.Sprintf("0x%x/0xffffffff", maxSeqNum)
+	return len(policies), nil
 }
 
 func getEncryptionMode() {
@@ -117,6 +124,10 @@ func getEncryptionMode() {
 
 	switch encryptionStatusResponse.Mode {
 	case models.EncryptionStatusModeIPsec:
+		// IPsec + cgroup v2 hybrid setups have been a recurring source of
+		// user-visible confusion (cgroup-array semantics differ under the
+		// unified hierarchy), so call it out explicitly here.
+		fmt.Printf("Cgroup mode: %-26s\n", cgroups.Version())
 		dumpIPsecStatus()
 	case models.EncryptionStatusModeWireguard:
 		dumpWireGuardStatus(encryptionStatusResponse)
@@ -165,11 +176,29 @@ func dumpIPsecStatus() {
 		Fatalf("Cannot get xfrm state: %s", err)
 	}
 	keys := ipsec.CountUniqueIPsecKeys(xfrmStates)
-	oseq := maxSequenceNumber()
+	maxSeqNum, found := maxSequenceNumberFromStates(xfrmStates)
+	oseq := "N/A"
+	if found {
+		oseq = fmt.Sprintf("0x%x/0xffffffff", maxSeqNum)
+	}
+	policyCount, err := xfrmPolicyCount()
+	if err != nil {
+		Fatalf("Cannot get xfrm policies: %s", err)
+	}
 	interfaces := getDecryptionInterfaces()
 	fmt.Printf("Decryption interface(s): %s\n", strings.Join(interfaces, ", "))
 	fmt.Printf("Keys in use: %-26d\n", keys)
 	fmt.Printf("Max Seq. Number: %s\n", oseq)
+	fmt.Printf("Policies installed: %-26d\n", policyCount)
+
+	watermark, lastRekey := rekey.RekeyStatus()
+	fmt.Printf("Rekey watermark: %-26s\n", fmt.Sprintf("0x%x/0xffffffff (threshold 0x%x)", watermark, rekey.DefaultSeqNumberRekeyThreshold))
+	if lastRekey.IsZero() {
+		fmt.Printf("Last seq-watermark rekey: %-26s\n", "never (in this process)")
+	} else {
+		fmt.Printf("Last seq-watermark rekey: %-26s\n", lastRekey.Format(time.RFC3339))
+	}
+
 	errCount, errMap := getXfrmStats("")
 	fmt.Printf("Errors: %-26d\n", errCount)
 	if errCount != 0 {
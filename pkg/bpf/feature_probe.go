@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package bpf
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// Features describes the set of BPF map types supported by the kernel the
+// agent is currently running on, as determined by runtime probing rather
+// than static assumptions about kernel version.
+type Features struct {
+	// MapTypes indicates, for every MapType known to this package that has
+	// a probeable equivalent, whether the running kernel is able to create
+	// a map of that type.
+	MapTypes map[MapType]bool
+}
+
+// FeatureProbe lazily probes the running kernel for BPF feature support and
+// caches the result for the lifetime of the process. It is safe for
+// concurrent use.
+type FeatureProbe struct {
+	once     sync.Once
+	features Features
+}
+
+// defaultFeatureProbe is the process-wide, lazily-initialized probe
+// consulted by GetMapType and other callers that need to know what the
+// kernel actually supports.
+var defaultFeatureProbe FeatureProbe
+
+// mapTypeProber is the minimal interface GetMapType consults. It exists so
+// tests can substitute a stub for defaultFeatureProbe and exercise
+// GetMapType's fallback branches without touching the host kernel.
+type mapTypeProber interface {
+	HasMapType(MapType) bool
+}
+
+// currentMapTypeProber is what GetMapType actually calls; defaults to
+// defaultFeatureProbe and is only ever swapped out in tests.
+var currentMapTypeProber mapTypeProber = &defaultFeatureProbe
+
+// Probe runs (on first call) or returns the cached result of probing the
+// kernel for its BPF map type support. The probe creates and immediately
+// discards a minimal map of each type, which lets it distinguish
+// "unsupported by this kernel" from "supported, but we lack the
+// privileges to create it" instead of conflating the two as a single
+// failure.
+func (p *FeatureProbe) Probe() (Features, error) {
+	p.once.Do(func() {
+		p.features = probeMapTypes()
+	})
+	return p.features, nil
+}
+
+// HasMapType returns true if the running kernel supports creating maps of
+// the given type. It triggers the probe on first use.
+func (p *FeatureProbe) HasMapType(t MapType) bool {
+	features, err := p.Probe()
+	if err != nil {
+		log.WithError(err).Debug("Failed to probe BPF map type support")
+		return false
+	}
+	supported, ok := features.MapTypes[t]
+	return ok && supported
+}
+
+// probeMapTypes attempts to create (and immediately close) a minimal map of
+// every known MapType, classifying the outcome of each attempt.
+func probeMapTypes() Features {
+	features := Features{MapTypes: make(map[MapType]bool, MapTypeMaximum)}
+
+	for t := MapTypeHash; t < MapTypeMaximum; t++ {
+		ebpfType, ok := mapTypeToEbpfType(t)
+		if !ok {
+			continue
+		}
+		features.MapTypes[t] = probeMapType(t, ebpfType)
+	}
+
+	return features
+}
+
+// probeMapType attempts to create a single, minimal map of the given type
+// and reports whether the kernel supports it. EPERM indicates the kernel
+// understands the map type but the process lacks CAP_BPF / CAP_SYS_ADMIN;
+// that is treated as supported since the map type itself is not the
+// problem. EINVAL indicates the kernel has no notion of the map type at
+// all.
+func probeMapType(t MapType, ebpfType ebpf.MapType) bool {
+	spec := &ebpf.MapSpec{
+		Name:       "cilium_probe",
+		Type:       ebpfType,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	}
+
+	switch t {
+	case MapTypeLPMTrie:
+		spec.KeySize = 8
+		spec.Flags = unix.BPF_F_NO_PREALLOC
+	case MapTypeArrayOfMaps, MapTypeHashOfMaps:
+		spec.InnerMap = &ebpf.MapSpec{
+			Type:       ebpf.Array,
+			KeySize:    4,
+			ValueSize:  4,
+			MaxEntries: 1,
+		}
+	case MapTypeQueue, MapTypeStack:
+		// These map types have no keys; KeySize must be zero.
+		spec.KeySize = 0
+	case MapTypeRingBuf:
+		// Ring buffers have no keys, and the kernel's ringbuf_map_alloc
+		// rejects any max_entries that isn't both a power of two and
+		// page-aligned with EINVAL, regardless of whether
+		// BPF_MAP_TYPE_RINGBUF itself is supported.
+		spec.KeySize = 0
+		spec.ValueSize = 0
+		spec.MaxEntries = uint32(unix.Getpagesize())
+	case MapTypeSkStorage, MapTypeInodeStorage, MapTypeTaskStorage:
+		// The kernel's bpf_local_storage allocator rejects creation of
+		// these map types unless BPF_F_NO_PREALLOC is set, regardless of
+		// whether the map type itself is supported; matches
+		// allowsPreallocation()/requiresPreallocation() for these types.
+		spec.Flags = unix.BPF_F_NO_PREALLOC
+	}
+
+	m, err := ebpf.NewMapWithOptions(spec, ebpf.MapOptions{})
+	switch {
+	case err == nil:
+		m.Close()
+		return true
+	case errors.Is(err, unix.EPERM):
+		log.WithError(err).Debugf("Map type %s supported by kernel but blocked by permissions", t)
+		return true
+	case errors.Is(err, unix.EINVAL):
+		log.Debugf("Map type %s not supported by kernel", t)
+		return false
+	default:
+		log.WithError(err).Debugf("Unexpected error probing map type %s, assuming unsupported", t)
+		return false
+	}
+}
+
+// mapTypeToEbpfType translates a local MapType into the equivalent
+// github.com/cilium/ebpf MapType so it can be probed or created directly
+// against the kernel. The second return value is false for map types that
+// have no probeable equivalent (e.g. MapTypeUnspec).
+func mapTypeToEbpfType(t MapType) (ebpf.MapType, bool) {
+	switch t {
+	case MapTypeHash:
+		return ebpf.Hash, true
+	case MapTypeArray:
+		return ebpf.Array, true
+	case MapTypeProgArray:
+		return ebpf.ProgramArray, true
+	case MapTypePerfEventArray:
+		return ebpf.PerfEventArray, true
+	case MapTypePerCPUHash:
+		return ebpf.PerCPUHash, true
+	case MapTypePerCPUArray:
+		return ebpf.PerCPUArray, true
+	case MapTypeStackTrace:
+		return ebpf.StackTrace, true
+	case MapTypeCgroupArray:
+		return ebpf.CGroupArray, true
+	case MapTypeLRUHash:
+		return ebpf.LRUHash, true
+	case MapTypeLRUPerCPUHash:
+		return ebpf.LRUCPUHash, true
+	case MapTypeLPMTrie:
+		return ebpf.LPMTrie, true
+	case MapTypeArrayOfMaps:
+		return ebpf.ArrayOfMaps, true
+	case MapTypeHashOfMaps:
+		return ebpf.HashOfMaps, true
+	case MapTypeDevMap:
+		return ebpf.DevMap, true
+	case MapTypeSockMap:
+		return ebpf.SockMap, true
+	case MapTypeCPUMap:
+		return ebpf.CPUMap, true
+	case MapTypeXSKMap:
+		return ebpf.XSKMap, true
+	case MapTypeSockHash:
+		return ebpf.SockHash, true
+	case MapTypeCgroupStorage:
+		return ebpf.CGroupStorage, true
+	case MapTypeReuseportSockArray:
+		return ebpf.ReusePortSockArray, true
+	case MapTypePerCPUCgroupStorage:
+		return ebpf.PerCPUCGroupStorage, true
+	case MapTypeQueue:
+		return ebpf.Queue, true
+	case MapTypeStack:
+		return ebpf.Stack, true
+	case MapTypeSkStorage:
+		return ebpf.SkStorage, true
+	case MapTypeDevMapHash:
+		return ebpf.DevMapHash, true
+	case MapTypeStructOps:
+		return ebpf.StructOpsMap, true
+	case MapTypeRingBuf:
+		return ebpf.RingBuf, true
+	case MapTypeInodeStorage:
+		return ebpf.InodeStorage, true
+	case MapTypeTaskStorage:
+		return ebpf.TaskStorage, true
+	case MapTypeBloomFilter:
+		return ebpf.BloomFilter, true
+	}
+	return ebpf.MapType(0), false
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package bpf
+
+import "testing"
+
+// TestMapTypeToEbpfTypeCoversAllKnownTypes ensures every MapType probeMapTypes
+// iterates over (MapTypeHash up to, but excluding, MapTypeMaximum) has an
+// ebpf.MapType mapping. A gap here would silently drop that type out of
+// Features.MapTypes, making HasMapType always report it unsupported.
+func TestMapTypeToEbpfTypeCoversAllKnownTypes(t *testing.T) {
+	for mt := MapTypeHash; mt < MapTypeMaximum; mt++ {
+		if _, ok := mapTypeToEbpfType(mt); !ok {
+			t.Errorf("mapTypeToEbpfType(%s) = _, false; want a mapping for every type between MapTypeHash and MapTypeMaximum", mt)
+		}
+	}
+}
+
+// TestMapTypeToEbpfTypeRejectsSentinels checks that the iota sentinels
+// bracketing the real map types are correctly excluded.
+func TestMapTypeToEbpfTypeRejectsSentinels(t *testing.T) {
+	for _, mt := range []MapType{MapTypeUnspec, MapTypeMaximum} {
+		if _, ok := mapTypeToEbpfType(mt); ok {
+			t.Errorf("mapTypeToEbpfType(%s) = _, true; want false for sentinel values", mt)
+		}
+	}
+}
+
+// stubProber is a mapTypeProber whose answers are fixed by the test,
+// letting GetMapType's fallback branches be exercised without depending on
+// the host kernel's actual feature set.
+type stubProber map[MapType]bool
+
+func (s stubProber) HasMapType(t MapType) bool {
+	return s[t]
+}
+
+// withStubProber swaps currentMapTypeProber for stub for the duration of
+// the test, restoring the original afterwards.
+func withStubProber(t *testing.T, stub stubProber) {
+	t.Helper()
+	original := currentMapTypeProber
+	currentMapTypeProber = stub
+	t.Cleanup(func() { currentMapTypeProber = original })
+}
+
+func TestGetMapTypeFallbacks(t *testing.T) {
+	tests := []struct {
+		name      string
+		supported stubProber
+		in        MapType
+		want      MapType
+	}{
+		{
+			name:      "LPMTrie falls back to Hash when unsupported",
+			supported: stubProber{},
+			in:        MapTypeLPMTrie,
+			want:      MapTypeHash,
+		},
+		{
+			name:      "LPMTrie kept when supported",
+			supported: stubProber{MapTypeLPMTrie: true},
+			in:        MapTypeLPMTrie,
+			want:      MapTypeLPMTrie,
+		},
+		{
+			name:      "LRUHash falls back to Hash when unsupported",
+			supported: stubProber{},
+			in:        MapTypeLRUHash,
+			want:      MapTypeHash,
+		},
+		{
+			name:      "RingBuf falls back to PerfEventArray when unsupported",
+			supported: stubProber{},
+			in:        MapTypeRingBuf,
+			want:      MapTypePerfEventArray,
+		},
+		{
+			name:      "RingBuf kept when supported",
+			supported: stubProber{MapTypeRingBuf: true},
+			in:        MapTypeRingBuf,
+			want:      MapTypeRingBuf,
+		},
+		{
+			name:      "SkStorage falls back to Hash when unsupported",
+			supported: stubProber{},
+			in:        MapTypeSkStorage,
+			want:      MapTypeHash,
+		},
+		{
+			name:      "InodeStorage falls back to Hash when unsupported",
+			supported: stubProber{},
+			in:        MapTypeInodeStorage,
+			want:      MapTypeHash,
+		},
+		{
+			name:      "TaskStorage falls back to Hash when unsupported",
+			supported: stubProber{},
+			in:        MapTypeTaskStorage,
+			want:      MapTypeHash,
+		},
+		{
+			name:      "map type with no fallback logic is returned unchanged",
+			supported: stubProber{},
+			in:        MapTypeArray,
+			want:      MapTypeArray,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStubProber(t, tt.supported)
+			if got := GetMapType(tt.in); got != tt.want {
+				t.Errorf("GetMapType(%s) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -15,16 +15,10 @@
 package bpf
 
 import (
-	"bufio"
-	"bytes"
-	"fmt"
-	"os"
 	"time"
 
+	"github.com/cilium/cilium/pkg/cgroups"
 	"github.com/cilium/cilium/pkg/controller"
-	"github.com/cilium/cilium/pkg/logging/logfields"
-
-	"github.com/sirupsen/logrus"
 )
 
 // MapType is an enumeration for valid BPF map types
@@ -51,6 +45,18 @@ const (
 	MapTypeCPUMap
 	MapTypeXSKMap
 	MapTypeSockHash
+	MapTypeCgroupStorage
+	MapTypeReuseportSockArray
+	MapTypePerCPUCgroupStorage
+	MapTypeQueue
+	MapTypeStack
+	MapTypeSkStorage
+	MapTypeDevMapHash
+	MapTypeStructOps
+	MapTypeRingBuf
+	MapTypeInodeStorage
+	MapTypeTaskStorage
+	MapTypeBloomFilter
 	// MapTypeMaximum is the maximum supported known map type.
 	MapTypeMaximum
 
@@ -71,10 +77,6 @@ const (
 
 var (
 	mapControllers = controller.NewManager()
-
-	// supportedMapTypes maps from a MapType to a bool indicating whether
-	// the currently running kernel supports the map type.
-	supportedMapTypes = make(map[MapType]bool)
 )
 
 func (t MapType) String() string {
@@ -113,13 +115,39 @@ func (t MapType) String() string {
 		return "CPU Redirect Map"
 	case MapTypeSockHash:
 		return "Socket Hash"
+	case MapTypeCgroupStorage:
+		return "Cgroup Storage"
+	case MapTypeReuseportSockArray:
+		return "Reuseport Socket Array"
+	case MapTypePerCPUCgroupStorage:
+		return "Per-CPU Cgroup Storage"
+	case MapTypeQueue:
+		return "Queue"
+	case MapTypeStack:
+		return "Stack"
+	case MapTypeSkStorage:
+		return "Socket-local Storage"
+	case MapTypeDevMapHash:
+		return "Device Map (hash)"
+	case MapTypeStructOps:
+		return "Struct Ops"
+	case MapTypeRingBuf:
+		return "Ring Buffer"
+	case MapTypeInodeStorage:
+		return "Inode-local Storage"
+	case MapTypeTaskStorage:
+		return "Task-local Storage"
+	case MapTypeBloomFilter:
+		return "Bloom Filter"
 	}
 
 	return "Unknown"
 }
 
 func (t MapType) allowsPreallocation() bool {
-	if t == MapTypeLPMTrie {
+	switch t {
+	case MapTypeLPMTrie, MapTypeQueue, MapTypeStack, MapTypeRingBuf,
+		MapTypeSkStorage, MapTypeInodeStorage, MapTypeTaskStorage:
 		return false
 	}
 	return true
@@ -127,7 +155,9 @@ func (t MapType) allowsPreallocation() bool {
 
 func (t MapType) requiresPreallocation() bool {
 	switch t {
-	case MapTypeHash, MapTypePerCPUHash, MapTypeLPMTrie, MapTypeHashOfMaps:
+	case MapTypeHash, MapTypePerCPUHash, MapTypeLPMTrie, MapTypeHashOfMaps,
+		MapTypeQueue, MapTypeStack, MapTypeRingBuf,
+		MapTypeSkStorage, MapTypeInodeStorage, MapTypeTaskStorage:
 		return false
 	}
 	return true
@@ -161,64 +191,32 @@ func (d DesiredAction) String() string {
 	}
 }
 
-// mapTypeToFeatureString maps a MapType into a string defined by run_probes.sh
-func mapTypeToFeatureString(mt MapType) string {
-	var featureString string
-	switch mt {
-	case MapTypeLPMTrie:
-		featureString = fmt.Sprintf("#define HAVE_LPM_MAP_TYPE")
-	case MapTypeLRUHash:
-		featureString = fmt.Sprintf("#define HAVE_LRU_MAP_TYPE")
-	default:
-		break
-	}
-	return featureString
-}
-
-// ReadFeatureProbes reads the bpf_features.h file at the specified path (as
-// generated by bpf/run_probes.sh), and stores the results of the kernel
-// feature probing.
-func ReadFeatureProbes(filename string) {
-	f, err := os.Open(filename)
-	if err != nil {
-		// Should not happen; the caller ensured that the file exists
-		log.WithFields(logrus.Fields{
-			logfields.Path: filename,
-		}).WithError(err).Fatal("Failed to read feature probes")
-	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		for mapType := MapTypeHash; mapType < MapTypeMaximum; mapType++ {
-			featureString := mapTypeToFeatureString(mapType)
-			if featureString != "" &&
-				bytes.Compare(scanner.Bytes(), []byte(featureString)) == 0 {
-				log.Debugf("Detected support for map type %s", mapType.String())
-				supportedMapTypes[mapType] = true
-			}
-		}
-	}
-
-	for mapType := MapTypeHash; mapType < MapTypeMaximum; mapType++ {
-		if mapTypeToFeatureString(mapType) == "" {
-			log.Debugf("Skipping support detection for map type %s", mapType.String())
-		} else if _, probed := supportedMapTypes[mapType]; !probed {
-			log.Debugf("Detected no support for map type %s", mapType.String())
-			supportedMapTypes[mapType] = false
-		}
-	}
-}
-
 // GetMapType determines whether the specified map type is supported by the
-// kernel (as determined by ReadFeatureProbes()), and if the map type is not
-// supported, returns a more primitive map type that may be used to implement
-// the map on older implementations. Otherwise, returns the specified map type.
+// kernel (as determined by runtime probing via currentMapTypeProber), and if
+// the map type is not supported, returns a more primitive map type that may
+// be used to implement the map on older kernels. Otherwise, returns the
+// specified map type. MapTypeCgroupArray is the exception: it is not probed,
+// and is replaced by MapTypeCgroupStorage whenever the host runs the unified
+// (v2) cgroup hierarchy.
 func GetMapType(t MapType) MapType {
 	switch t {
-	case MapTypeLPMTrie:
-		fallthrough
-	case MapTypeLRUHash:
-		if !supportedMapTypes[t] {
+	case MapTypeCgroupArray:
+		// Cgroup-array semantics assume the legacy, per-controller
+		// hierarchy; under the unified hierarchy prefer the storage map
+		// types instead.
+		if cgroups.Version() == cgroups.VersionV2 {
+			return MapTypeCgroupStorage
+		}
+	case MapTypeLPMTrie, MapTypeLRUHash:
+		if !currentMapTypeProber.HasMapType(t) {
+			return MapTypeHash
+		}
+	case MapTypeRingBuf:
+		if !currentMapTypeProber.HasMapType(t) {
+			return MapTypePerfEventArray
+		}
+	case MapTypeSkStorage, MapTypeInodeStorage, MapTypeTaskStorage:
+		if !currentMapTypeProber.HasMapType(t) {
 			return MapTypeHash
 		}
 	}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+//go:build ebpf_map_backend
+
+package bpf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/cilium/cilium/pkg/controller"
+)
+
+// EbpfMap is an alternative to the default, CGO-based map implementation:
+// it opens and creates maps purely through github.com/cilium/ebpf, so an
+// agent built with the ebpf_map_backend tag links no CGO at all. It is a
+// separate type with its own constructor, OpenOrCreateEbpfMap: the build tag
+// only compiles it in, and callers that construct the default Map keep
+// using that Map.
+type EbpfMap struct {
+	inner *ebpf.Map
+
+	name       string
+	mapType    MapType
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	pinPath    string
+}
+
+// OpenOrCreateEbpfMap opens the map pinned at the file pinPath if it already
+// exists, or creates it otherwise. A pinned map is used as loaded; its type
+// and sizes are not compared with the given ones. pinPath, when
+// non-empty, is always the literal path of the pin file itself (not its
+// containing directory) on both the load and create paths, matching
+// ebpf.LoadPinnedMap's contract; ebpf.MapOptions.PinPath is the directory
+// cilium/ebpf joins with the map's name to derive that same file, so it is
+// derived from pinPath rather than passed through directly. It mirrors the
+// open/create semantics of the CGO-based Map so existing DesiredAction
+// reconciliation code does not need to special-case the backend.
+func OpenOrCreateEbpfMap(name string, mapType MapType, keySize, valueSize, maxEntries uint32, pinPath string) (*EbpfMap, error) {
+	ebpfType, ok := mapTypeToEbpfType(mapType)
+	if !ok {
+		return nil, fmt.Errorf("map type %s has no ebpf_map_backend equivalent", mapType)
+	}
+
+	m := &EbpfMap{
+		name:       name,
+		mapType:    mapType,
+		keySize:    keySize,
+		valueSize:  valueSize,
+		maxEntries: maxEntries,
+		pinPath:    pinPath,
+	}
+
+	if pinPath != "" {
+		if pinned, err := ebpf.LoadPinnedMap(pinPath, nil); err == nil {
+			m.inner = pinned
+			return m, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to load pinned map %s: %w", pinPath, err)
+		}
+	}
+
+	// cilium/ebpf joins MapOptions.PinPath with spec.Name to compute the
+	// file it pins the map at, so spec.Name must be pinPath's base name
+	// for the two to agree on where the map ends up.
+	specName := name
+	pinDir := ""
+	if pinPath != "" {
+		specName = filepath.Base(pinPath)
+		pinDir = filepath.Dir(pinPath)
+	}
+
+	spec := &ebpf.MapSpec{
+		Name:       specName,
+		Type:       ebpfType,
+		KeySize:    keySize,
+		ValueSize:  valueSize,
+		MaxEntries: maxEntries,
+	}
+	if pinPath != "" {
+		spec.Pinning = ebpf.PinByName
+	}
+
+	inner, err := ebpf.NewMapWithOptions(spec, ebpf.MapOptions{
+		PinPath: pinDir,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create map %s: %w", name, err)
+	}
+	m.inner = inner
+	return m, nil
+}
+
+// Close releases the underlying map file descriptor. It does not remove
+// the pin, mirroring the CGO-based Map's Close().
+func (m *EbpfMap) Close() error {
+	return m.inner.Close()
+}
+
+// Update inserts or updates key to value, following the same DesiredAction
+// semantics the CGO-based Map uses.
+func (m *EbpfMap) Update(key, value interface{}) error {
+	return m.inner.Update(key, value, ebpf.UpdateAny)
+}
+
+// Lookup reads the value for key into value.
+func (m *EbpfMap) Lookup(key, value interface{}) error {
+	return m.inner.Lookup(key, value)
+}
+
+// Delete removes key from the map.
+func (m *EbpfMap) Delete(key interface{}) error {
+	return m.inner.Delete(key)
+}
+
+// reconcileEbpfMap applies action to the map, registering a controller
+// under mapControllers (the same controller.Manager the CGO-based Map uses
+// for its error resolver) so the existing errorResolverSchedulerMinInterval
+// and maxSyncErrors error-resolver semantics continue to govern retry
+// behavior regardless of which backend is selected: the controller is
+// removed, rather than retried forever, once maxSyncErrors consecutive
+// attempts have failed.
+func (m *EbpfMap) reconcileEbpfMap(controllerName string, action DesiredAction, key, value interface{}) {
+	consecutiveErrors := 0
+	mapControllers.UpdateController(controllerName, controller.ControllerParams{
+		RunInterval: errorResolverSchedulerMinInterval,
+		DoFunc: func(ctx context.Context) error {
+			var err error
+			switch action {
+			case Insert:
+				err = m.Update(key, value)
+			case Delete:
+				err = m.Delete(key)
+			}
+			if err == nil {
+				consecutiveErrors = 0
+				return nil
+			}
+
+			consecutiveErrors++
+			if consecutiveErrors >= maxSyncErrors {
+				mapControllers.RemoveController(controllerName)
+				return fmt.Errorf("giving up on %s after %d consecutive errors: %w", controllerName, consecutiveErrors, err)
+			}
+			return err
+		},
+	})
+}
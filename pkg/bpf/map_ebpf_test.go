@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+//go:build ebpf_map_backend
+
+package bpf
+
+import "testing"
+
+// TestOpenOrCreateEbpfMapRejectsUnprobeableType ensures map types with no
+// ebpf.MapType equivalent (e.g. MapTypeUnspec) fail fast instead of handing
+// the kernel a bogus map type.
+func TestOpenOrCreateEbpfMapRejectsUnprobeableType(t *testing.T) {
+	_, err := OpenOrCreateEbpfMap("cilium_test_unspec", MapTypeUnspec, 4, 4, 1, "")
+	if err == nil {
+		t.Fatal("expected an error for a map type with no ebpf_map_backend equivalent")
+	}
+}
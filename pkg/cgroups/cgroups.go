@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+// Package cgroups detects which cgroup hierarchy (v1 or the unified v2) the
+// host is running, since several BPF map types and attach points behave
+// differently depending on it.
+package cgroups
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Version identifies which cgroup hierarchy is in effect on the host.
+type Version int
+
+const (
+	// VersionUnknown is returned when the cgroup version could not be
+	// determined, i.e. statfs(2) on cgroupMountPoint failed (for example
+	// because the path does not exist).
+	VersionUnknown Version = iota
+	// VersionV1 is the legacy, per-controller cgroup hierarchy.
+	VersionV1
+	// VersionV2 is the unified cgroup hierarchy.
+	VersionV2
+)
+
+func (v Version) String() string {
+	switch v {
+	case VersionV1:
+		return "v1"
+	case VersionV2:
+		return "v2"
+	default:
+		return "unknown"
+	}
+}
+
+// cgroupMountPoint is the well-known location cgroups are mounted at on
+// Cilium-supported hosts.
+const cgroupMountPoint = "/sys/fs/cgroup"
+
+// cgroup2MagicNumber is the f_type statfs(2) reports for a cgroup2
+// filesystem; see CGROUP2_SUPER_MAGIC in <linux/magic.h>.
+const cgroup2MagicNumber = 0x63677270
+
+// Version detects whether the host is running unified cgroups (v2) or the
+// legacy, per-controller hierarchy (v1) by checking the filesystem type
+// backing cgroupMountPoint. Any filesystem other than cgroup2 there,
+// including a directory with nothing mounted on it, is reported as VersionV1.
+func Version() Version {
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(cgroupMountPoint, &statfs); err != nil {
+		return VersionUnknown
+	}
+	if int64(statfs.Type) == cgroup2MagicNumber {
+		return VersionV2
+	}
+	return VersionV1
+}
@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cgroups
+
+import "testing"
+
+func TestVersionString(t *testing.T) {
+	tests := map[Version]string{
+		VersionUnknown: "unknown",
+		VersionV1:      "v1",
+		VersionV2:      "v2",
+	}
+	for version, want := range tests {
+		if got := version.String(); got != want {
+			t.Errorf("Version(%d).String() = %q, want %q", version, got, want)
+		}
+	}
+}
+
+func TestVersionDetectsCurrentHost(t *testing.T) {
+	// Version() must never panic and must return one of the known
+	// values, even on hosts without /sys/fs/cgroup mounted.
+	switch v := Version(); v {
+	case VersionUnknown, VersionV1, VersionV2:
+	default:
+		t.Errorf("Version() returned unexpected value %d", v)
+	}
+}
@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+// Package rekey watches the IPsec anti-replay sequence number and
+// proactively rotates the key before it wraps around.
+//
+// It lives under pkg/ipsec rather than being named ipsec itself to avoid
+// colliding with the existing pkg/common/ipsec import name used throughout
+// the CLI and daemon.
+package rekey
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/cilium/cilium/pkg/controller"
+)
+
+const (
+	// ciliumReqId is the XFRM reqid Cilium uses to tie its IPsec security
+	// policies to their matching state.
+	ciliumReqId = 1
+
+	// DefaultSeqNumberRekeyThreshold is the default high-water mark (out of
+	// a maximum sequence number of 0xFFFFFFFF) at which StartSeqNumberRekeyWatcher
+	// proactively rotates the IPsec key. Callers pass the threshold they
+	// want to StartSeqNumberRekeyWatcher; this package has no flag of its own.
+	DefaultSeqNumberRekeyThreshold uint32 = 0xC0000000
+
+	// maxConsecutiveErrors mirrors pkg/bpf's maxSyncErrors: the watcher
+	// controller is removed, rather than retried forever, once this many
+	// consecutive runs have failed.
+	maxConsecutiveErrors = 512
+
+	rekeyWatermarkControllerName = "ipsec-seq-number-rekey"
+	rekeyWatermarkCheckInterval  = 30 * time.Second
+)
+
+var (
+	rekeyControllers = controller.NewManager()
+
+	metricRekeyTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cilium",
+		Subsystem: "ipsec",
+		Name:      "rekey_total",
+		Help:      "Number of times Cilium has proactively rotated the IPsec key, by reason",
+	}, []string{"reason"})
+)
+
+// RegisterMetrics registers this package's collectors on reg. Callers that
+// expose a Prometheus endpoint (e.g. `cilium encrypt status --prometheus`,
+// or the daemon's own metrics server) must call this against whichever
+// registry they actually serve; this package does not register itself on
+// prometheus.DefaultRegisterer.
+func RegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(metricRekeyTotal)
+}
+
+// watermarkMu guards watermark and lastRekeyTime, which are written from the
+// controller goroutine started by StartSeqNumberRekeyWatcher and read from
+// RekeyStatus by whatever assembles the healthz Encryption payload or the
+// CLI's status output.
+var (
+	watermarkMu   sync.Mutex
+	watermark     uint32
+	lastRekeyTime time.Time
+)
+
+// RekeyStatus returns the most recently observed XFRM sequence-number
+// watermark and the time of the last watermark-triggered rekey (the zero
+// Time if no rekey has happened yet in this process). Callers assembling
+// the daemon's healthz Encryption payload, or printing `cilium encrypt
+// status`, should surface both alongside the existing encryption mode.
+func RekeyStatus() (currentWatermark uint32, lastRekey time.Time) {
+	watermarkMu.Lock()
+	defer watermarkMu.Unlock()
+	return watermark, lastRekeyTime
+}
+
+// RekeyFunc rotates the active IPsec key. It is supplied by the caller so
+// this package does not need to know about keyfile management.
+type RekeyFunc func() error
+
+// StartSeqNumberRekeyWatcher registers a controller that periodically reads
+// the highest XFRM output sequence number in use across the Cilium-managed
+// IPsec SAs and, once it crosses threshold (out of a maximum of
+// 0xFFFFFFFF), invokes rekey to rotate the IPsec key before the sequence
+// counter wraps around and the kernel starts dropping packets. Matching
+// pkg/bpf's maxSyncErrors error resolver, the controller removes itself
+// after maxConsecutiveErrors consecutive failures rather than retrying
+// forever.
+func StartSeqNumberRekeyWatcher(threshold uint32, rekey RekeyFunc) {
+	consecutiveErrors := 0
+	rekeyControllers.UpdateController(rekeyWatermarkControllerName, controller.ControllerParams{
+		RunInterval: rekeyWatermarkCheckInterval,
+		DoFunc: func(ctx context.Context) error {
+			seq, err := maxSequenceNumber()
+			if err != nil {
+				return giveUpAfterTooManyErrors(&consecutiveErrors, fmt.Errorf("failed to read xfrm sequence number: %w", err))
+			}
+
+			watermarkMu.Lock()
+			watermark = seq
+			watermarkMu.Unlock()
+
+			if seq < threshold {
+				consecutiveErrors = 0
+				return nil
+			}
+
+			if err := rekey(); err != nil {
+				return giveUpAfterTooManyErrors(&consecutiveErrors, fmt.Errorf("sequence watermark 0x%x crossed threshold 0x%x but rekey failed: %w", seq, threshold, err))
+			}
+
+			watermarkMu.Lock()
+			lastRekeyTime = time.Now()
+			watermarkMu.Unlock()
+			metricRekeyTotal.WithLabelValues("seq-watermark").Inc()
+			consecutiveErrors = 0
+			return nil
+		},
+	})
+}
+
+// giveUpAfterTooManyErrors removes the watermark controller once
+// *consecutiveErrors reaches maxConsecutiveErrors, instead of letting it
+// retry a failing check forever.
+func giveUpAfterTooManyErrors(consecutiveErrors *int, err error) error {
+	*consecutiveErrors++
+	if *consecutiveErrors >= maxConsecutiveErrors {
+		rekeyControllers.RemoveController(rekeyWatermarkControllerName)
+		return fmt.Errorf("giving up on %s after %d consecutive errors: %w", rekeyWatermarkControllerName, *consecutiveErrors, err)
+	}
+	return err
+}
+
+// maxSequenceNumber enumerates the Cilium-managed IPsec SAs and returns the
+// highest XFRM output sequence number currently in use.
+func maxSequenceNumber() (uint32, error) {
+	states, err := netlink.XfrmStateList(netlink.FAMILY_ALL)
+	if err != nil {
+		return 0, err
+	}
+
+	var maxSeq uint32
+	for _, state := range states {
+		if state.Reqid != ciliumReqId || state.Replay == nil {
+			continue
+		}
+		if state.Replay.OSeq > maxSeq {
+			maxSeq = state.Replay.OSeq
+		}
+	}
+	return maxSeq, nil
+}